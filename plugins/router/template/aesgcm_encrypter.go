@@ -0,0 +1,222 @@
+package templaterouter
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kekVersionLen is the length, in bytes, of the hex-encoded KEK fingerprint prefixed to every
+// envelope produced by aesGCMKeyEncrypter.
+const kekVersionLen = 12
+
+// defaultKEKRefreshInterval bounds how often KEKVersion re-fetches the KEK from its source.
+// templateRouter.rotateKEKIfNeeded calls KEKVersion on every commit, which runs with stateLock
+// held, so refreshing on every call would mean a slow or unreachable KMSURL stalls every
+// AddRoute/RemoveRoute/AddEndpoints for up to the HTTP client's timeout; caching the version for
+// this long keeps that cost off the common path while still detecting rotation promptly.
+const defaultKEKRefreshInterval = 30 * time.Second
+
+// KEKConfig selects where newAESGCMKeyEncrypter sources the key-encryption-key from.  Exactly one
+// field should be set; the zero value means no KeyEncrypter is configured at all.
+type KEKConfig struct {
+	// EnvVar is the name of an environment variable holding the base64-encoded 32-byte KEK.
+	EnvVar string
+	// FilePath is a file holding the base64-encoded 32-byte KEK.  It is re-read at most once per
+	// defaultKEKRefreshInterval, so replacing its contents on disk rotates the KEK without
+	// restarting the router.
+	FilePath string
+	// KMSURL is an HTTP(S) endpoint that returns the current base64-encoded 32-byte KEK as its
+	// response body, e.g. a KMS-backed sidecar.  Also re-read at most once per
+	// defaultKEKRefreshInterval.
+	KMSURL string
+}
+
+// aesGCMKeyEncrypter implements KeyEncrypter using AES-256-GCM.  The KEK is cached in memory and
+// refreshed from whichever source KEKConfig names at most once per refreshInterval, so rotating it
+// at the source is picked up by templateRouter.rotateKEKIfNeeded without restarting the router and
+// without a slow or unreachable KMSURL stalling every commit.  Decrypt can only unwrap envelopes
+// tagged with the KEK version currently cached: once a rotation has dropped an old KEK from its
+// source, anything still encrypted under it can no longer be read back and must be re-encrypted
+// before that happens.
+type aesGCMKeyEncrypter struct {
+	cfg             KEKConfig
+	httpClient      *http.Client
+	refreshInterval time.Duration
+
+	mu             sync.Mutex
+	currentVersion string
+	currentKey     []byte
+	lastRefreshed  time.Time
+}
+
+// newAESGCMKeyEncrypter loads the KEK named by cfg and returns a ready-to-use KeyEncrypter, or an
+// error if the source can't be read or doesn't hold a valid 32-byte key.
+func newAESGCMKeyEncrypter(cfg KEKConfig) (*aesGCMKeyEncrypter, error) {
+	e := &aesGCMKeyEncrypter{
+		cfg:             cfg,
+		httpClient:      &http.Client{Timeout: 5 * time.Second},
+		refreshInterval: defaultKEKRefreshInterval,
+	}
+	if _, err := e.refreshKEK(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// loadKEKBytes fetches the current KEK from whichever source cfg names and decodes it.
+func (e *aesGCMKeyEncrypter) loadKEKBytes() ([]byte, error) {
+	var encoded string
+	switch {
+	case len(e.cfg.EnvVar) > 0:
+		encoded = os.Getenv(e.cfg.EnvVar)
+		if len(encoded) == 0 {
+			return nil, fmt.Errorf("KEK env var %s is not set", e.cfg.EnvVar)
+		}
+	case len(e.cfg.FilePath) > 0:
+		dat, err := ioutil.ReadFile(e.cfg.FilePath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read KEK file %s: %v", e.cfg.FilePath, err)
+		}
+		encoded = strings.TrimSpace(string(dat))
+	case len(e.cfg.KMSURL) > 0:
+		resp, err := e.httpClient.Get(e.cfg.KMSURL)
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch KEK from %s: %v", e.cfg.KMSURL, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unable to fetch KEK from %s: unexpected status %s", e.cfg.KMSURL, resp.Status)
+		}
+		dat, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read KEK response from %s: %v", e.cfg.KMSURL, err)
+		}
+		encoded = strings.TrimSpace(string(dat))
+	default:
+		return nil, fmt.Errorf("no KEK source configured")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("KEK is not valid base64: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("KEK must decode to 32 bytes for AES-256, got %d", len(key))
+	}
+	return key, nil
+}
+
+// refreshKEK unconditionally reloads the KEK from its source - which may do network I/O for
+// KMSURL - and caches the result, returning the (possibly unchanged) version.  Callers on the hot
+// path should go through KEKVersion instead, which only calls this once refreshInterval has
+// elapsed.
+func (e *aesGCMKeyEncrypter) refreshKEK() (string, error) {
+	key, err := e.loadKEKBytes()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(key)
+	version := hex.EncodeToString(sum[:])[:kekVersionLen]
+
+	e.mu.Lock()
+	e.currentKey = key
+	e.currentVersion = version
+	e.lastRefreshed = time.Now()
+	e.mu.Unlock()
+
+	return version, nil
+}
+
+// snapshot returns the currently cached key and version without triggering a refresh.
+func (e *aesGCMKeyEncrypter) snapshot() ([]byte, string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.currentKey, e.currentVersion
+}
+
+func (e *aesGCMKeyEncrypter) gcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt implements KeyEncrypter.
+func (e *aesGCMKeyEncrypter) Encrypt(plaintext []byte) ([]byte, error) {
+	key, version := e.snapshot()
+	gcm, err := e.gcm(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+	envelope := append([]byte(version), ciphertext...)
+	return envelope, nil
+}
+
+// Decrypt implements KeyEncrypter.
+func (e *aesGCMKeyEncrypter) Decrypt(envelope []byte) ([]byte, error) {
+	if len(envelope) < kekVersionLen {
+		return nil, fmt.Errorf("envelope too short to contain a KEK version")
+	}
+	version := string(envelope[:kekVersionLen])
+	rest := envelope[kekVersionLen:]
+
+	key, currentVersion := e.snapshot()
+	if version != currentVersion {
+		return nil, fmt.Errorf("envelope was encrypted with KEK version %s but only %s is currently cached; re-encrypt before rotating the old KEK out", version, currentVersion)
+	}
+
+	gcm, err := e.gcm(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < gcm.NonceSize() {
+		return nil, fmt.Errorf("envelope too short to contain a nonce")
+	}
+	nonce, ciphertext := rest[:gcm.NonceSize()], rest[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// KEKVersion implements KeyEncrypter.  It only reloads the KEK from its source - doing network I/O
+// for a KMSURL - once refreshInterval has elapsed since the last reload; in between it returns the
+// cached version immediately, so templateRouter.rotateKEKIfNeeded calling this on every commit
+// doesn't do I/O under stateLock on every mutation.  If a reload fails (e.g. the source is briefly
+// unreachable), the last known-good version is returned rather than breaking every subsequent
+// Encrypt/Decrypt call.
+func (e *aesGCMKeyEncrypter) KEKVersion() string {
+	e.mu.Lock()
+	stale := time.Since(e.lastRefreshed) >= e.refreshInterval
+	cached := e.currentVersion
+	e.mu.Unlock()
+
+	if !stale {
+		return cached
+	}
+
+	if version, err := e.refreshKEK(); err == nil {
+		return version
+	}
+	// Wait out another refreshInterval before retrying a failed reload, so an unreachable source
+	// isn't hit again on every call.
+	e.mu.Lock()
+	e.lastRefreshed = time.Now()
+	e.mu.Unlock()
+	return cached
+}
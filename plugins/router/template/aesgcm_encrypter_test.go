@@ -0,0 +1,93 @@
+package templaterouter
+
+import (
+	"encoding/base64"
+	"os"
+	"testing"
+)
+
+func setTestKEK(t *testing.T, envVar string, key []byte) {
+	t.Helper()
+	if err := os.Setenv(envVar, base64.StdEncoding.EncodeToString(key)); err != nil {
+		t.Fatalf("unable to set %s: %v", envVar, err)
+	}
+}
+
+func TestAESGCMKeyEncrypterRoundTrip(t *testing.T) {
+	const envVar = "TEST_ROUTER_KEK"
+	setTestKEK(t, envVar, make([]byte, 32))
+
+	e, err := newAESGCMKeyEncrypter(KEKConfig{EnvVar: envVar})
+	if err != nil {
+		t.Fatalf("newAESGCMKeyEncrypter: %v", err)
+	}
+
+	plaintext := []byte("super secret private key material")
+	envelope, err := e.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if string(envelope) == string(plaintext) {
+		t.Fatalf("Encrypt returned plaintext unchanged")
+	}
+
+	got, err := e.Decrypt(envelope)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if string(got) != string(plaintext) {
+		t.Fatalf("round trip mismatch: got %q, want %q", got, plaintext)
+	}
+}
+
+func TestAESGCMKeyEncrypterRejectsStaleVersion(t *testing.T) {
+	const envVar = "TEST_ROUTER_KEK_ROTATE"
+	key1 := make([]byte, 32)
+	key1[0] = 1
+	setTestKEK(t, envVar, key1)
+
+	e, err := newAESGCMKeyEncrypter(KEKConfig{EnvVar: envVar})
+	if err != nil {
+		t.Fatalf("newAESGCMKeyEncrypter: %v", err)
+	}
+
+	envelope, err := e.Encrypt([]byte("payload"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	key2 := make([]byte, 32)
+	key2[0] = 2
+	setTestKEK(t, envVar, key2)
+	// Force KEKVersion to treat the cache as stale, as it would naturally once refreshInterval has
+	// elapsed, instead of waiting defaultKEKRefreshInterval out in the test.
+	e.refreshInterval = 0
+	e.KEKVersion()
+
+	if _, err := e.Decrypt(envelope); err == nil {
+		t.Fatalf("expected Decrypt to reject an envelope encrypted under a rotated-out KEK version")
+	}
+}
+
+func TestAESGCMKeyEncrypterKEKVersionCachedWithinTTL(t *testing.T) {
+	const envVar = "TEST_ROUTER_KEK_TTL"
+	key1 := make([]byte, 32)
+	key1[0] = 1
+	setTestKEK(t, envVar, key1)
+
+	e, err := newAESGCMKeyEncrypter(KEKConfig{EnvVar: envVar})
+	if err != nil {
+		t.Fatalf("newAESGCMKeyEncrypter: %v", err)
+	}
+	cached := e.KEKVersion()
+
+	key2 := make([]byte, 32)
+	key2[0] = 2
+	setTestKEK(t, envVar, key2)
+
+	// refreshInterval hasn't elapsed, so KEKVersion must not reload the KEK from its source - this
+	// is what keeps rotateKEKIfNeeded from doing I/O under stateLock on every single commit.
+	if got := e.KEKVersion(); got != cached {
+		t.Fatalf("expected KEKVersion to return the cached version %q before refreshInterval elapses, got %q", cached, got)
+	}
+}
@@ -0,0 +1,95 @@
+package templaterouter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// unwrappedKeyDir is a tmpfs-backed directory holding plaintext copies of private keys (and of the
+// default certificate bundle) for the backend process to read.  Being tmpfs, it does not survive a
+// reboot.  The router does not remove these copies when it exits; on start it rewrites the ones it
+// needs (see writeDefaultCert and restoreCertificateRefCounts).
+const unwrappedKeyDir = "/dev/shm/router-keys/"
+
+// certManager is responsible for writing certificate and key material to disk for use by the
+// underlying router implementation.
+type certManager struct {
+	// keyEncrypter, if set, wraps private key material in an authenticated-encryption envelope
+	// before it's written to certDir, and unwraps it into unwrappedKeyDir for templates to read.
+	keyEncrypter KeyEncrypter
+}
+
+// certHash returns the hex-encoded SHA-256 hash of content.  Certificate files are named by this
+// hash so that every route sharing the same certificate content shares the same file on disk,
+// instead of each route owning its own copy keyed by host.
+func certHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeCertificate writes a single certificate (or concatenated bundle) to <directory>/<id>.pem
+func (cm *certManager) writeCertificate(directory, id string, cert []byte) error {
+	return ioutil.WriteFile(fmt.Sprintf("%s%s.pem", directory, id), cert, 0644)
+}
+
+// writeCertificateFile writes the certificate and (optional) private key for a single, already
+// hashed, certificate to certDir.  It is invoked when a hash gains its first reference (see
+// templateRouter.trackCertificates and writeCertificates), and again for every hash when
+// templateRouter.rotateKEKIfNeeded re-queues them after a KEK change.
+// When a KeyEncrypter is configured, the on-disk .key file holds the encrypted envelope and a
+// plaintext copy is maintained on tmpfs for the backend process to read.
+func (cm *certManager) writeCertificateFile(hash string, cert Certificate) error {
+	if len(cert.Contents) > 0 {
+		if err := cm.writeCertificate(certDir, hash, []byte(cert.Contents)); err != nil {
+			return err
+		}
+	}
+	if len(cert.PrivateKey) == 0 {
+		return nil
+	}
+
+	keyBytes := []byte(cert.PrivateKey)
+	if cm.keyEncrypter == nil {
+		return ioutil.WriteFile(fmt.Sprintf("%s%s.key", certDir, hash), keyBytes, 0600)
+	}
+
+	envelope, err := cm.keyEncrypter.Encrypt(keyBytes)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt private key for %s: %v", hash, err)
+	}
+	if err := ioutil.WriteFile(fmt.Sprintf("%s%s.key", certDir, hash), envelope, 0600); err != nil {
+		return err
+	}
+	return cm.writeUnwrappedKeyCopy(hash, keyBytes)
+}
+
+// writeUnwrappedKeyCopy stores a plaintext copy of a private key on tmpfs so the backend process
+// can read it without needing to understand the on-disk envelope format.
+func (cm *certManager) writeUnwrappedKeyCopy(hash string, plaintext []byte) error {
+	if err := os.MkdirAll(unwrappedKeyDir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fmt.Sprintf("%s%s.key", unwrappedKeyDir, hash), plaintext, 0600)
+}
+
+// deleteCertificateFile removes the certificate, key, and (if present) unwrapped tmpfs key files
+// for the given content hash.  A missing file is not treated as an error since a hash may never
+// have had a key (CA-only certs).
+func (cm *certManager) deleteCertificateFile(hash string) error {
+	paths := []string{
+		fmt.Sprintf("%s%s.pem", certDir, hash),
+		fmt.Sprintf("%s%s.key", certDir, hash),
+	}
+	if cm.keyEncrypter != nil {
+		paths = append(paths, fmt.Sprintf("%s%s.key", unwrappedKeyDir, hash))
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
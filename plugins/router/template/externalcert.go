@@ -0,0 +1,21 @@
+package templaterouter
+
+// ExternalCertificateResolver resolves a Route's ExternalCertificate reference (a namespace +
+// Secret name) into the PEM bytes the router needs.  Secrets are expected to follow the same
+// convention as the kubernetes.io/tls Secret type: a "tls.crt", a "tls.key", and an optional
+// "ca.crt" entry.
+type ExternalCertificateResolver interface {
+	// ResolveCertificate returns the host certificate, private key, and CA certificate (if
+	// present) for the named Secret, along with the UID and resourceVersion of the Secret that
+	// produced them so callers can detect when a previously resolved value is stale.
+	ResolveCertificate(namespace, name string) (*ResolvedCertificate, error)
+}
+
+// ResolvedCertificate is the result of resolving an ExternalCertificate reference to a Secret.
+type ResolvedCertificate struct {
+	UID             string
+	ResourceVersion string
+	Certificate     []byte
+	Key             []byte
+	CACertificate   []byte
+}
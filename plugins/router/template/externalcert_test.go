@@ -0,0 +1,142 @@
+package templaterouter
+
+import (
+	"fmt"
+	"testing"
+
+	routeapi "github.com/openshift/origin/pkg/route/api"
+)
+
+type fakeCertResolver struct {
+	calls    int
+	resolved *ResolvedCertificate
+	err      error
+}
+
+func (f *fakeCertResolver) ResolveCertificate(namespace, name string) (*ResolvedCertificate, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.resolved, nil
+}
+
+func newExternalCertRouter(resolver ExternalCertificateResolver) *templateRouter {
+	return &templateRouter{
+		certResolver:      resolver,
+		externalCertCache: map[string]*ResolvedCertificate{},
+	}
+}
+
+func externalRoute(host string) *routeapi.Route {
+	return &routeapi.Route{
+		Namespace: "ns",
+		Name:      "route",
+		Host:      host,
+		TLS: &routeapi.TLSConfig{
+			Termination:         routeapi.TLSTerminationEdge,
+			ExternalCertificate: &routeapi.LocalObjectReference{Namespace: "ns", Name: "tls-secret"},
+		},
+	}
+}
+
+func TestResolveExternalCertificateReusesCachedValueWhenUnchanged(t *testing.T) {
+	resolver := &fakeCertResolver{resolved: &ResolvedCertificate{
+		UID:             "uid-1",
+		ResourceVersion: "1",
+		Certificate:     []byte("cert-v1"),
+		Key:             []byte("key-v1"),
+	}}
+	r := newExternalCertRouter(resolver)
+
+	first, err := r.resolveExternalCertificate("ns", "tls-secret")
+	if err != nil {
+		t.Fatalf("resolveExternalCertificate: %v", err)
+	}
+	second, err := r.resolveExternalCertificate("ns", "tls-secret")
+	if err != nil {
+		t.Fatalf("resolveExternalCertificate: %v", err)
+	}
+
+	if first != second {
+		t.Fatalf("expected the same cached *ResolvedCertificate to be returned when UID/ResourceVersion are unchanged")
+	}
+	if resolver.calls != 2 {
+		t.Fatalf("expected the underlying resolver to still be called on every resolveExternalCertificate call, got %d calls", resolver.calls)
+	}
+}
+
+func TestResolveExternalCertificateInvalidatesOnNewResourceVersion(t *testing.T) {
+	resolver := &fakeCertResolver{resolved: &ResolvedCertificate{
+		UID:             "uid-1",
+		ResourceVersion: "1",
+		Certificate:     []byte("cert-v1"),
+	}}
+	r := newExternalCertRouter(resolver)
+
+	if _, err := r.resolveExternalCertificate("ns", "tls-secret"); err != nil {
+		t.Fatalf("resolveExternalCertificate: %v", err)
+	}
+
+	resolver.resolved = &ResolvedCertificate{
+		UID:             "uid-1",
+		ResourceVersion: "2",
+		Certificate:     []byte("cert-v2"),
+	}
+	second, err := r.resolveExternalCertificate("ns", "tls-secret")
+	if err != nil {
+		t.Fatalf("resolveExternalCertificate: %v", err)
+	}
+
+	if string(second.Certificate) != "cert-v2" {
+		t.Fatalf("expected a changed ResourceVersion to invalidate the cache, got stale Certificate %q", second.Certificate)
+	}
+}
+
+func TestAddExternalCertificateFallsBackWithoutResolver(t *testing.T) {
+	r := newExternalCertRouter(nil)
+	cfg := &ServiceAliasConfig{Certificates: map[string]Certificate{}}
+	route := externalRoute("app.example.com")
+
+	r.addExternalCertificate(cfg, route)
+
+	if len(cfg.Certificates) != 0 {
+		t.Fatalf("expected no certificates to be populated when no resolver is configured, got %v", cfg.Certificates)
+	}
+}
+
+func TestAddExternalCertificateFallsBackOnResolveError(t *testing.T) {
+	resolver := &fakeCertResolver{err: fmt.Errorf("secret not found")}
+	r := newExternalCertRouter(resolver)
+	cfg := &ServiceAliasConfig{Certificates: map[string]Certificate{}}
+	route := externalRoute("app.example.com")
+
+	r.addExternalCertificate(cfg, route)
+
+	if len(cfg.Certificates) != 0 {
+		t.Fatalf("expected no certificates to be populated when resolution fails, got %v", cfg.Certificates)
+	}
+}
+
+func TestAddExternalCertificatePopulatesFromResolvedSecret(t *testing.T) {
+	resolver := &fakeCertResolver{resolved: &ResolvedCertificate{
+		UID:             "uid-1",
+		ResourceVersion: "1",
+		Certificate:     []byte("cert-bytes"),
+		Key:             []byte("key-bytes"),
+		CACertificate:   []byte("ca-bytes"),
+	}}
+	r := newExternalCertRouter(resolver)
+	cfg := &ServiceAliasConfig{Certificates: map[string]Certificate{}}
+	route := externalRoute("app.example.com")
+
+	r.addExternalCertificate(cfg, route)
+
+	hostCert, ok := cfg.Certificates["app.example.com"]
+	if !ok || hostCert.Contents != "cert-bytes" || hostCert.PrivateKey != "key-bytes" {
+		t.Fatalf("expected host certificate populated from resolved Secret, got %+v", cfg.Certificates)
+	}
+	if caCert, ok := cfg.Certificates["app.example.com"+caCertPostfix]; !ok || caCert.Contents != "ca-bytes" {
+		t.Fatalf("expected CA certificate populated from resolved Secret, got %+v", cfg.Certificates)
+	}
+}
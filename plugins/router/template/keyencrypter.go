@@ -0,0 +1,17 @@
+package templaterouter
+
+// KeyEncrypter wraps plaintext bytes (a private key, or the serialized router state) in an
+// authenticated-encryption envelope before they are written to disk, and unwraps the envelope
+// again on read.  Implementations are expected to use AES-256-GCM with a key-encryption-key (KEK)
+// sourced from an env var, a file, or a KMS URL supplied to newTemplateRouter.
+type KeyEncrypter interface {
+	// Encrypt wraps plaintext into an envelope tagged with the KEK version that produced it.
+	Encrypt(plaintext []byte) (envelope []byte, err error)
+	// Decrypt unwraps an envelope previously produced by Encrypt.  The envelope's KEK version tag
+	// lets an implementation reject an envelope whose KEK it no longer holds, as
+	// aesGCMKeyEncrypter does for any version other than the one currently cached.
+	Decrypt(envelope []byte) (plaintext []byte, err error)
+	// KEKVersion returns the version of the KEK currently used for Encrypt, so callers can detect
+	// when the KEK has been rotated and re-encrypt accordingly.
+	KEKVersion() string
+}
@@ -0,0 +1,108 @@
+package templaterouter
+
+import (
+	"errors"
+	"testing"
+
+	routeapi "github.com/openshift/origin/pkg/route/api"
+)
+
+func routeConfig(host string) ServiceAliasConfig {
+	return ServiceAliasConfig{Host: host, TLSTermination: routeapi.TLSTerminationEdge}
+}
+
+func TestQuarantineChangedRoutesSeededLastGoodState(t *testing.T) {
+	state := map[string]ServiceUnit{
+		"ns-good": {
+			Name:                "ns-good",
+			ServiceAliasConfigs: map[string]ServiceAliasConfig{"ns-good-route": routeConfig("good.example.com")},
+		},
+		"ns-bad": {
+			Name:                "ns-bad",
+			ServiceAliasConfigs: map[string]ServiceAliasConfig{"ns-bad-route": routeConfig("bad.example.com")},
+		},
+	}
+
+	r := &templateRouter{
+		state:       state,
+		routeStatus: map[string]RouteStatus{},
+	}
+	// Simulate a restart: lastGoodState is seeded from the freshly loaded state, as newTemplateRouter
+	// now does, instead of starting out empty.
+	r.lastGoodState = r.cloneState()
+
+	// Now mutate just the bad route, as a subsequent AddRoute would.
+	badSU := r.state["ns-bad"]
+	badCfg := badSU.ServiceAliasConfigs["ns-bad-route"]
+	badCfg.Path = "/changed"
+	badSU.ServiceAliasConfigs["ns-bad-route"] = badCfg
+	r.state["ns-bad"] = badSU
+
+	r.quarantineChangedRoutes(errors.New("validation failed"))
+
+	if _, ok := r.state["ns-good"].ServiceAliasConfigs["ns-good-route"]; !ok {
+		t.Fatalf("unchanged route was quarantined even though lastGoodState was seeded from the loaded state")
+	}
+	if _, ok := r.state["ns-bad"].ServiceAliasConfigs["ns-bad-route"]; ok {
+		t.Fatalf("changed route was not quarantined")
+	}
+}
+
+func TestQuarantineChangedRoutesReleasesCertificates(t *testing.T) {
+	cert := Certificate{ID: "bad.example.com", Contents: "cert-bytes", PrivateKey: "key-bytes"}
+	hash := certHash([]byte(cert.Contents))
+
+	badCfg := edgeConfig("bad.example.com", cert)
+	r := &templateRouter{
+		state: map[string]ServiceUnit{
+			"ns-bad": {
+				Name:                "ns-bad",
+				ServiceAliasConfigs: map[string]ServiceAliasConfig{"ns-bad-route": badCfg},
+			},
+		},
+		routeStatus:          map[string]RouteStatus{},
+		certificateRefCounts: map[string]int{},
+		pendingCertWrites:    map[string]Certificate{},
+		certificatesByHash:   map[string]Certificate{},
+	}
+	r.restoreCertificateRefCounts()
+	r.lastGoodState = map[string]ServiceUnit{}
+
+	if got := r.certificateRefCounts[hash]; got != 1 {
+		t.Fatalf("expected refcount 1 before quarantine, got %d", got)
+	}
+
+	r.quarantineChangedRoutes(errors.New("validation failed"))
+
+	if _, ok := r.certificateRefCounts[hash]; ok {
+		t.Fatalf("quarantining the only route referencing a certificate leaked its refcount entry")
+	}
+}
+
+func TestCloneStateDeepCopiesCertificates(t *testing.T) {
+	cfg := routeConfig("app.example.com")
+	cfg.Certificates = map[string]Certificate{
+		"app.example.com": {ID: "app.example.com", Contents: "v1"},
+	}
+
+	r := &templateRouter{
+		state: map[string]ServiceUnit{
+			"ns": {
+				Name:                "ns",
+				ServiceAliasConfigs: map[string]ServiceAliasConfig{"ns-route": cfg},
+			},
+		},
+	}
+
+	clone := r.cloneState()
+
+	// Mutate the live state's Certificates map in place, as applyProvisionedCertificate/
+	// trackCertificates do, and make sure the clone doesn't see it.
+	liveCfg := r.state["ns"].ServiceAliasConfigs["ns-route"]
+	liveCfg.Certificates["app.example.com"] = Certificate{ID: "app.example.com", Contents: "v2"}
+
+	clonedCert := clone["ns"].ServiceAliasConfigs["ns-route"].Certificates["app.example.com"]
+	if clonedCert.Contents != "v1" {
+		t.Fatalf("expected cloned Certificates map to be independent of live state, got Contents=%q", clonedCert.Contents)
+	}
+}
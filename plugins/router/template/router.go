@@ -6,7 +6,11 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/golang/glog"
 
@@ -27,6 +31,23 @@ const (
 
 	caCertPostfix   = "_ca"
 	destCertPostfix = "_pod"
+
+	provisionedCertsFile = "/var/lib/containers/router/provisioned-certs.json"
+	// certRenewalCheckInterval is how often the renewal loop wakes up to look for provisioned
+	// certificates that have crossed 2/3 of their lifetime.
+	certRenewalCheckInterval = 1 * time.Hour
+
+	// defaultCommitInterval is how long the background worker waits after the first dirty signal
+	// before actually committing, so a burst of mutations (e.g. a rolling deployment updating N
+	// pods) coalesces into a single write+reload cycle.
+	defaultCommitInterval = 500 * time.Millisecond
+
+	// provisioningRequestTimeout bounds how long a host is considered to have an in-flight
+	// RequestCertificate call.  CertificateProvisioner only reports success via onIssued, so a
+	// request that errors or is simply dropped would otherwise leave pendingProvisioning set
+	// forever; once this much time has passed since the request was made, requestProvisionedCertificate
+	// is willing to try again.
+	provisioningRequestTimeout = 2 * time.Minute
 )
 
 // templateRouter is a backend-agnostic router implementation
@@ -44,6 +65,86 @@ type templateRouter struct {
 	defaultCertificate string
 	// if the default certificate is populated then this will be filled in so it can be passed to the templates
 	defaultCertificatePath string
+	// certResolver resolves Route.TLS.ExternalCertificate references to Secret contents.  May be
+	// nil, in which case routes that reference an external certificate fall back to the default
+	// certificate.
+	certResolver ExternalCertificateResolver
+	// externalCertCache caches the most recently resolved Secret contents for each "namespace/name"
+	// external certificate reference, keyed by that reference.  The resolver is still called on every
+	// AddRoute; the cached value is returned when its UID+ResourceVersion match the fresh result, and
+	// replaced by the fresh result otherwise.
+	externalCertCache map[string]*ResolvedCertificate
+	// certificateRefCounts tracks how many ServiceAliasConfigs currently reference each
+	// certificate, keyed by content hash.  A file is only written to disk on a 0->1 transition
+	// and only removed once the count drops back to 0, see trackCertificates/releaseCertificates.
+	certificateRefCounts map[string]int
+	// pendingCertWrites holds the certificates, keyed by content hash, that have not yet been
+	// written to disk.  writeCertificates drains this on every Commit.
+	pendingCertWrites map[string]Certificate
+	// provisioner requests certificates from an external CA for routes that don't have one.  May
+	// be nil, in which case such routes simply fall back to the default certificate as before.
+	provisioner CertificateProvisioner
+	// provisionedCerts holds the most recently issued certificate for each host, persisted to
+	// provisionedCertsFile so issuance survives a router restart.
+	provisionedCerts map[string]IssuedCertificate
+	// pendingProvisioning tracks, for each host with an in-flight RequestCertificate call, when
+	// that call was made.  A burst of AddRoute calls for the same host doesn't trigger duplicate
+	// issuance requests; once provisioningRequestTimeout has passed without onIssued being called,
+	// the host is considered stale and eligible to be requested again (see requestProvisionedCertificate).
+	pendingProvisioning map[string]time.Time
+	// validatorCommand, if set, is split on whitespace and invoked as "<validatorCommand> <tempConfigFile>"
+	// against each rendered template before it is moved into place, e.g. "haproxy -c -f".  A non-zero exit
+	// causes Commit to quarantine the routes that changed since the last good config rather than
+	// reloading with a config the backend itself would reject.
+	validatorCommand string
+	// routeStatus records whether a route is currently being served, keyed by "id/routeKey", so
+	// callers can see which routes were rejected by the validator.  See RouteStatuses.
+	routeStatus map[string]RouteStatus
+	// lastGoodState is a snapshot of r.state as of the last Commit that passed validation.  A
+	// failed validation is diffed against this to find which routes to quarantine.
+	lastGoodState map[string]ServiceUnit
+	// keyEncrypter, if set, is used to encrypt private key material at rest: every written .key
+	// file under certDir as well as routeFile itself.  Shared with certManager.
+	keyEncrypter KeyEncrypter
+	// certificatesByHash remembers the last Certificate seen for each content hash, so that
+	// rotateKEKIfNeeded can re-queue every hash for writing when the KEK is rotated.
+	certificatesByHash map[string]Certificate
+	// lastKEKVersion is the KEK version in use as of the last Commit; used to detect rotation.
+	lastKEKVersion string
+	// stateLock guards every field read or written by the mutating methods (AddRoute, RemoveRoute,
+	// AddEndpoints, ...) and by commit(), since both the background worker goroutine and
+	// certificate provisioning callbacks can now touch router state concurrently.
+	stateLock sync.Mutex
+	// commitInterval is how long the worker waits after the first dirty signal before committing,
+	// coalescing any further signals that arrive in the meantime.
+	commitInterval time.Duration
+	// dirty is a buffered (cap 1) channel; a pending value means a commit is owed.  Mutating
+	// methods send to it via enqueueCommit instead of calling Commit synchronously.
+	dirty chan struct{}
+	// statsLock guards stats, which is read by Stats() from any goroutine.
+	statsLock sync.Mutex
+	stats     routerStats
+}
+
+// routerStats accumulates the counters surfaced via templateRouter.Stats().
+type routerStats struct {
+	commits         int64
+	coalesced       int64
+	lastReloadTime  time.Duration
+	lastReloadError error
+}
+
+// Stats is a point-in-time snapshot of the router's commit/reload activity.
+type Stats struct {
+	// Commits is the number of commits actually performed by the worker (CommitNow calls it too).
+	Commits int64
+	// CoalesceRatio is the fraction of enqueued commits that were absorbed into another commit
+	// instead of triggering one of their own, i.e. coalesced / (commits + coalesced).
+	CoalesceRatio float64
+	// LastReloadTime is how long the most recent commit's writeConfig+reloadRouter cycle took.
+	LastReloadTime time.Duration
+	// LastReloadError is the error returned by the most recent commit, if any.
+	LastReloadError error
 }
 
 // templateConfig is a subset of the templateRouter information that should be passed to the template for generating
@@ -55,43 +156,128 @@ type templateData struct {
 	DefaultCertificate string
 }
 
-func newTemplateRouter(templates map[string]*template.Template, reloadScriptPath, defaultCertificate string) (*templateRouter, error) {
+func newTemplateRouter(templates map[string]*template.Template, reloadScriptPath, defaultCertificate string, certResolver ExternalCertificateResolver, provisionerConfig ProvisionerConfig, validatorCommand string, kekConfig KEKConfig, commitInterval time.Duration) (*templateRouter, error) {
 	glog.Infof("Creating a new template router")
+	if commitInterval <= 0 {
+		commitInterval = defaultCommitInterval
+	}
+
+	var keyEncrypter KeyEncrypter
+	if (kekConfig != KEKConfig{}) {
+		encrypter, err := newAESGCMKeyEncrypter(kekConfig)
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure KEK: %v", err)
+		}
+		keyEncrypter = encrypter
+	}
+
+	provisioner, err := newCertificateProvisioner(provisionerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure certificate provisioner: %v", err)
+	}
+
 	router := &templateRouter{
 		templates:              templates,
 		reloadScriptPath:       reloadScriptPath,
 		state:                  map[string]ServiceUnit{},
-		certManager:            certManager{},
+		certManager:            certManager{keyEncrypter: keyEncrypter},
 		defaultCertificate:     defaultCertificate,
 		defaultCertificatePath: "",
+		certResolver:           certResolver,
+		externalCertCache:      map[string]*ResolvedCertificate{},
+		certificateRefCounts:   map[string]int{},
+		pendingCertWrites:      map[string]Certificate{},
+		provisioner:            provisioner,
+		pendingProvisioning:    map[string]time.Time{},
+		validatorCommand:       validatorCommand,
+		routeStatus:            map[string]RouteStatus{},
+		lastGoodState:          map[string]ServiceUnit{},
+		keyEncrypter:           keyEncrypter,
+		certificatesByHash:     map[string]Certificate{},
+		commitInterval:         commitInterval,
+		dirty:                  make(chan struct{}, 1),
 	}
 	if err := router.writeDefaultCert(); err != nil {
 		return nil, err
 	}
+	glog.Infof("Reading any persisted provisioned certificates")
+	if err := router.readProvisionedCerts(); err != nil {
+		return nil, err
+	}
 	glog.Infof("Reading any persisted state")
 	if err := router.readState(); err != nil {
 		return nil, err
 	}
+	// Seed lastGoodState from whatever was just loaded so the first commit's quarantine diff (if
+	// validation fails) is against the routes that were already serving, not an empty map - an
+	// empty lastGoodState would make every loaded route look "changed" and quarantine all of them.
+	router.lastGoodState = router.cloneState()
 	glog.Infof("Performing initial commit")
-	if err := router.Commit(); err != nil {
+	if err := router.CommitNow(); err != nil {
 		return nil, err
 	}
+	if router.provisioner != nil {
+		go router.runCertificateRenewalLoop()
+	}
+	go router.runWorker()
 	return router, nil
 }
 
 // writeDefaultCert is called a single time during init to write out the default certificate
+// bundle (the concatenated certificate, private key, and CA described by the defaultCertificate
+// field's doc comment above).  When a KeyEncrypter is configured, the bundle - private key
+// included - is wrapped in an authenticated-encryption envelope before being written to certDir,
+// the same as every other .key file; the backend process still needs the bundle in plaintext, so
+// an unwrapped copy is kept on tmpfs and defaultCertificatePath points there instead of at certDir.
 func (r *templateRouter) writeDefaultCert() error {
-	if len(r.defaultCertificate) > 0 {
-		glog.Infof("Writing default certificate to %s", certDir)
+	if len(r.defaultCertificate) == 0 {
+		return nil
+	}
+	glog.Infof("Writing default certificate to %s", certDir)
+
+	if r.keyEncrypter == nil {
 		err := r.certManager.writeCertificate(certDir, defaultCertName, []byte(r.defaultCertificate))
 		if err == nil {
 			r.defaultCertificatePath = fmt.Sprintf("%s%s.pem", certDir, defaultCertName)
 		}
 		return err
 	}
+
+	envelope, err := r.keyEncrypter.Encrypt([]byte(r.defaultCertificate))
+	if err != nil {
+		return fmt.Errorf("unable to encrypt default certificate bundle: %v", err)
+	}
+	if err := r.certManager.writeCertificate(certDir, defaultCertName, envelope); err != nil {
+		return err
+	}
+	if err := r.certManager.writeUnwrappedKeyCopy(defaultCertName, []byte(r.defaultCertificate)); err != nil {
+		return err
+	}
+	r.defaultCertificatePath = fmt.Sprintf("%s%s.key", unwrappedKeyDir, defaultCertName)
 	return nil
 }
 
+// readProvisionedCerts loads any certificates previously issued by a CertificateProvisioner so
+// they survive a router restart instead of being re-requested from the CA.
+func (r *templateRouter) readProvisionedCerts() error {
+	dat, err := ioutil.ReadFile(provisionedCertsFile)
+	if err != nil {
+		r.provisionedCerts = make(map[string]IssuedCertificate)
+		return nil
+	}
+
+	return json.Unmarshal(dat, &r.provisionedCerts)
+}
+
+// writeProvisionedCerts persists the current set of provisioner-issued certificates to disk.
+func (r *templateRouter) writeProvisionedCerts() error {
+	dat, err := json.MarshalIndent(r.provisionedCerts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(provisionedCertsFile, dat, 0600)
+}
+
 func (r *templateRouter) readState() error {
 	dat, err := ioutil.ReadFile(routeFile)
 	// XXX: rework
@@ -100,21 +286,152 @@ func (r *templateRouter) readState() error {
 		return nil
 	}
 
-	return json.Unmarshal(dat, &r.state)
+	if r.keyEncrypter != nil {
+		dat, err = r.keyEncrypter.Decrypt(dat)
+		if err != nil {
+			// Inline route.TLS.Key values are persisted in this file in plaintext otherwise, so a
+			// decryption failure must not be papered over by silently regenerating empty state.
+			return fmt.Errorf("unable to decrypt persisted router state, refusing to start: %v", err)
+		}
+	}
+
+	if err := json.Unmarshal(dat, &r.state); err != nil {
+		return err
+	}
+
+	r.restoreCertificateRefCounts()
+	return nil
+}
+
+// restoreCertificateRefCounts rebuilds certificateRefCounts (and the other in-memory certificate
+// bookkeeping derived from it) from the just-loaded r.state.  Without this, every route loaded from
+// routeFile would start with a refcount of 0 even though its certificate file is still on disk and
+// still referenced, so the very next RemoveRoute/DeleteServiceUnit touching a shared certificate
+// would delete that file out from under every other route still using it.
+func (r *templateRouter) restoreCertificateRefCounts() {
+	for _, su := range r.state {
+		for key, cfg := range su.ServiceAliasConfigs {
+			if r.shouldWriteCerts(&cfg) {
+				r.trackCertificates(&cfg)
+			}
+			su.ServiceAliasConfigs[key] = cfg
+		}
+	}
+}
+
+// CommitNow performs a synchronous commit, bypassing the coalescing work queue that mutating
+// methods otherwise feed via enqueueCommit.  Tests and callers that need to observe the result of
+// a specific mutation immediately should use this instead of waiting on the background worker.
+func (r *templateRouter) CommitNow() error {
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+	return r.commitLocked()
+}
+
+// commitLocked performs a commit and records its stats.  Callers must already hold stateLock.
+func (r *templateRouter) commitLocked() error {
+	start := time.Now()
+	err := r.commit()
+
+	r.statsLock.Lock()
+	r.stats.commits++
+	r.stats.lastReloadTime = time.Since(start)
+	r.stats.lastReloadError = err
+	r.statsLock.Unlock()
+
+	return err
+}
+
+// enqueueCommit marks the router dirty so the background worker picks up the change on its next
+// tick, coalescing it with any other mutation that arrives within the same commitInterval window.
+func (r *templateRouter) enqueueCommit() {
+	select {
+	case r.dirty <- struct{}{}:
+	default:
+		r.statsLock.Lock()
+		r.stats.coalesced++
+		r.statsLock.Unlock()
+	}
+}
+
+// runWorker drains commit requests in the background, coalescing bursts of mutations (e.g. a
+// rolling deployment updating N pods' endpoints) within commitInterval into a single
+// writeConfig+reloadRouter cycle instead of one per mutation.
+func (r *templateRouter) runWorker() {
+	for range r.dirty {
+		time.Sleep(r.commitInterval)
+
+		// drain any further signals that arrived during the coalesce window
+		for drained := true; drained; {
+			select {
+			case <-r.dirty:
+				r.statsLock.Lock()
+				r.stats.coalesced++
+				r.statsLock.Unlock()
+			default:
+				drained = false
+			}
+		}
+
+		if err := r.CommitNow(); err != nil {
+			glog.Errorf("Error committing router state: %v", err)
+		}
+	}
+}
+
+// Stats returns a snapshot of commit/reload metrics: how many commits the worker has actually
+// performed, what fraction of enqueued commits were coalesced into another commit instead of
+// running on their own, the duration of the most recent reload, and its error (if any).
+func (r *templateRouter) Stats() Stats {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+
+	total := r.stats.commits + r.stats.coalesced
+	var ratio float64
+	if total > 0 {
+		ratio = float64(r.stats.coalesced) / float64(total)
+	}
+
+	return Stats{
+		Commits:         r.stats.commits,
+		CoalesceRatio:   ratio,
+		LastReloadTime:  r.stats.lastReloadTime,
+		LastReloadError: r.stats.lastReloadError,
+	}
 }
 
-// Commit refreshes the backend and persists the router state.
-func (r *templateRouter) Commit() error {
+// commit refreshes the backend and persists the router state.  Before reloading, the rendered
+// config is validated; if validation fails the routes that changed since the last good config are
+// quarantined (marked degraded and removed from r.state) and the config is re-rendered and
+// re-validated, so a single malformed route cannot take down the whole router.  State is only
+// written to routeFile once the (possibly quarantined) state has passed validation, so a restart
+// never re-reads a route that was just rejected.  Callers must hold stateLock; use CommitNow.
+func (r *templateRouter) commit() error {
 	glog.V(4).Info("Commiting router changes")
 
-	if err := r.writeState(); err != nil {
+	r.rotateKEKIfNeeded()
+
+	if err := r.writeCertificates(); err != nil {
+		glog.Errorf("Error writing certificates: %v", err)
 		return err
 	}
 
-	if err := r.writeConfig(); err != nil {
+	if err := r.renderAndValidate(); err != nil {
+		glog.Errorf("Router configuration failed validation, quarantining the routes that changed and retrying: %v", err)
+		r.quarantineChangedRoutes(err)
+		if err := r.renderAndValidate(); err != nil {
+			return err
+		}
+	}
+
+	// Written after validation (and any quarantine) so routeFile always reflects what was actually
+	// rendered, never a pre-quarantine state that would be re-read as-is on the next restart.
+	if err := r.writeState(); err != nil {
 		return err
 	}
 
+	r.lastGoodState = r.cloneState()
+
 	if err := r.reloadRouter(); err != nil {
 		return err
 	}
@@ -122,6 +439,113 @@ func (r *templateRouter) Commit() error {
 	return nil
 }
 
+// renderAndValidate renders each template to a temporary file, runs it through r.validatorCommand
+// (when configured), and only then renames the temp files into place.  This keeps the previous,
+// known-good config files on disk untouched if validation fails.
+func (r *templateRouter) renderAndValidate() error {
+	tempPaths := map[string]string{}
+	for path, tmpl := range r.templates {
+		tempPath := path + ".tmp"
+		file, err := os.Create(tempPath)
+		if err != nil {
+			glog.Errorf("Error creating config file %v: %v", tempPath, err)
+			return err
+		}
+
+		err = tmpl.Execute(file, templateData{r.state, r.defaultCertificatePath})
+		file.Close()
+		if err != nil {
+			glog.Errorf("Error executing template for file %v: %v", tempPath, err)
+			return err
+		}
+
+		tempPaths[path] = tempPath
+	}
+
+	if validatorArgs := strings.Fields(r.validatorCommand); len(validatorArgs) > 0 {
+		for _, tempPath := range tempPaths {
+			args := append(append([]string{}, validatorArgs[1:]...), tempPath)
+			cmd := exec.Command(validatorArgs[0], args...)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				return fmt.Errorf("validation of %s failed: %v\n%s", tempPath, err, string(out))
+			}
+		}
+	}
+
+	for path, tempPath := range tempPaths {
+		if err := os.Rename(tempPath, path); err != nil {
+			glog.Errorf("Error moving %v into place as %v: %v", tempPath, path, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// quarantineChangedRoutes marks every ServiceAliasConfig that differs from the last-known-good
+// snapshot as degraded in r.routeStatus and removes it from r.state, so the retried render only
+// contains routes that were already serving successfully plus whatever is left unchanged.
+func (r *templateRouter) quarantineChangedRoutes(reason error) {
+	for id, su := range r.state {
+		lastGood, existed := r.lastGoodState[id]
+		for key, cfg := range su.ServiceAliasConfigs {
+			if existed {
+				if prevCfg, ok := lastGood.ServiceAliasConfigs[key]; ok && reflect.DeepEqual(prevCfg, cfg) {
+					continue
+				}
+			}
+			statusKey := fmt.Sprintf("%s/%s", id, key)
+			glog.Warningf("Marking route %s as degraded: %v", statusKey, reason)
+			r.routeStatus[statusKey] = RouteStatus{Degraded: true, Reason: reason.Error()}
+			if r.shouldWriteCerts(&cfg) {
+				r.releaseCertificates(&cfg)
+			}
+			delete(su.ServiceAliasConfigs, key)
+		}
+		r.state[id] = su
+	}
+}
+
+// cloneState returns a copy of r.state deep enough to diff against on the next Commit.  Besides
+// ServiceAliasConfigs, each config's Certificates map is also copied: onCertificateIssued and
+// trackCertificates mutate that map in place, and since a map is a reference, a shallow copy of
+// ServiceAliasConfig would let such a mutation "update" this snapshot too, making
+// reflect.DeepEqual in quarantineChangedRoutes blind to exactly the kind of change it needs to
+// catch.
+func (r *templateRouter) cloneState() map[string]ServiceUnit {
+	clone := make(map[string]ServiceUnit, len(r.state))
+	for id, su := range r.state {
+		configs := make(map[string]ServiceAliasConfig, len(su.ServiceAliasConfigs))
+		for key, cfg := range su.ServiceAliasConfigs {
+			if cfg.Certificates != nil {
+				certs := make(map[string]Certificate, len(cfg.Certificates))
+				for certID, cert := range cfg.Certificates {
+					certs[certID] = cert
+				}
+				cfg.Certificates = certs
+			}
+			configs[key] = cfg
+		}
+		su.ServiceAliasConfigs = configs
+		clone[id] = su
+	}
+	return clone
+}
+
+// RouteStatuses returns a snapshot of the status of every route the router has attempted to
+// admit, keyed by "id/routeKey".  A route missing from the map has never been rejected by the
+// validator.
+func (r *templateRouter) RouteStatuses() map[string]RouteStatus {
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+
+	statuses := make(map[string]RouteStatus, len(r.routeStatus))
+	for key, status := range r.routeStatus {
+		statuses[key] = status
+	}
+	return statuses
+}
+
 // writeState writes the state of this router to disk.
 func (r *templateRouter) writeState() error {
 	dat, err := json.MarshalIndent(r.state, "", "  ")
@@ -129,7 +553,16 @@ func (r *templateRouter) writeState() error {
 		glog.Errorf("Failed to marshal route table: %v", err)
 		return err
 	}
-	err = ioutil.WriteFile(routeFile, dat, 0644)
+
+	if r.keyEncrypter != nil {
+		dat, err = r.keyEncrypter.Encrypt(dat)
+		if err != nil {
+			glog.Errorf("Failed to encrypt route table: %v", err)
+			return err
+		}
+	}
+
+	err = ioutil.WriteFile(routeFile, dat, 0600)
 	if err != nil {
 		glog.Errorf("Failed to write route table: %v", err)
 		return err
@@ -138,46 +571,90 @@ func (r *templateRouter) writeState() error {
 	return nil
 }
 
-// writeConfig writes the config to disk
-func (r *templateRouter) writeConfig() error {
-	//write out any certificate files that don't exist
-	for _, serviceUnit := range r.state {
-		for _, cfg := range serviceUnit.ServiceAliasConfigs {
-			err := r.writeCertificates(&cfg)
-			if err != nil {
-				glog.Errorf("Error writing certificates for %s: %v", serviceUnit.Name, err)
-				return err
-			}
+// writeCertificates writes to disk any certificate that has not already been written.  Because
+// certificate files are named by content hash (see trackCertificates), a given file is written at
+// most once no matter how many routes reference it, turning what used to be an O(routes*certs)
+// rewrite on every Commit into work proportional to the number of distinct certificates added
+// since the last Commit.
+func (r *templateRouter) writeCertificates() error {
+	for hash, cert := range r.pendingCertWrites {
+		if err := r.certManager.writeCertificateFile(hash, cert); err != nil {
+			return err
 		}
+		delete(r.pendingCertWrites, hash)
 	}
+	return nil
+}
 
-	for path, template := range r.templates {
-		file, err := os.Create(path)
-		if err != nil {
-			glog.Errorf("Error creating config file %v: %v", path, err)
-			return err
+// trackCertificates computes the content hash of each certificate in cfg, records the resulting
+// on-disk path on the certificate, and increments the hash's refcount.  The file itself is only
+// queued for writing the first time a hash is seen (0->1), see writeCertificates.
+func (r *templateRouter) trackCertificates(cfg *ServiceAliasConfig) {
+	for id, cert := range cfg.Certificates {
+		if len(cert.Contents) == 0 {
+			continue
 		}
-
-		err = template.Execute(file, templateData{r.state, r.defaultCertificatePath})
-		if err != nil {
-			glog.Errorf("Error executing template for file %v: %v", path, err)
-			return err
+		hash := certHash([]byte(cert.Contents))
+		cert.Path = fmt.Sprintf("%s%s.pem", certDir, hash)
+		if len(cert.PrivateKey) > 0 {
+			cert.KeyPath = r.keyPathForHash(hash)
 		}
+		if r.certificateRefCounts[hash] == 0 {
+			r.pendingCertWrites[hash] = cert
+		}
+		r.certificateRefCounts[hash]++
+		r.certificatesByHash[hash] = cert
+		cfg.Certificates[id] = cert
+	}
+}
 
-		file.Close()
+// keyPathForHash returns the path templates should read a certificate's private key from: the
+// on-disk file directly when no KeyEncrypter is configured, or the tmpfs-backed unwrapped copy
+// certManager maintains alongside the encrypted file when one is.
+func (r *templateRouter) keyPathForHash(hash string) string {
+	if r.keyEncrypter == nil {
+		return fmt.Sprintf("%s%s.key", certDir, hash)
 	}
+	return fmt.Sprintf("%s%s.key", unwrappedKeyDir, hash)
+}
 
-	return nil
+// rotateKEKIfNeeded re-queues every known certificate for writing when the configured
+// KeyEncrypter reports a new KEK version, so rotating the key-encryption-key re-encrypts
+// persisted key material on the next Commit instead of requiring a restart of every route.
+func (r *templateRouter) rotateKEKIfNeeded() {
+	if r.keyEncrypter == nil {
+		return
+	}
+	version := r.keyEncrypter.KEKVersion()
+	if version == r.lastKEKVersion {
+		return
+	}
+	glog.Infof("KEK version changed from %q to %q, re-encrypting persisted key material", r.lastKEKVersion, version)
+	for hash, cert := range r.certificatesByHash {
+		r.pendingCertWrites[hash] = cert
+	}
+	r.lastKEKVersion = version
 }
 
-// writeCertificates attempts to write certificates only if the cfg requires it see shouldWriteCerts
-// for details
-func (r *templateRouter) writeCertificates(cfg *ServiceAliasConfig) error {
-	if r.shouldWriteCerts(cfg) {
-		//TODO: better way so this doesn't need to create lots of files every time state is written, probably too expensive
-		return r.certManager.writeCertificatesForConfig(cfg)
+// releaseCertificates decrements the refcount for each certificate in cfg, deleting the on-disk
+// file once the last reference to it is gone.
+func (r *templateRouter) releaseCertificates(cfg *ServiceAliasConfig) {
+	for _, cert := range cfg.Certificates {
+		if len(cert.Contents) == 0 {
+			continue
+		}
+		hash := certHash([]byte(cert.Contents))
+		if r.certificateRefCounts[hash] <= 1 {
+			delete(r.certificateRefCounts, hash)
+			delete(r.pendingCertWrites, hash)
+			delete(r.certificatesByHash, hash)
+			if err := r.certManager.deleteCertificateFile(hash); err != nil {
+				glog.Errorf("Error deleting certificate files for %s: %v", hash, err)
+			}
+		} else {
+			r.certificateRefCounts[hash]--
+		}
 	}
-	return nil
 }
 
 // reloadRouter executes the router's reload script.
@@ -192,6 +669,9 @@ func (r *templateRouter) reloadRouter() error {
 
 // CreateServiceUnit creates a new service named with the given id.
 func (r *templateRouter) CreateServiceUnit(id string) {
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+
 	service := ServiceUnit{
 		Name:                id,
 		ServiceAliasConfigs: make(map[string]ServiceAliasConfig),
@@ -199,28 +679,47 @@ func (r *templateRouter) CreateServiceUnit(id string) {
 	}
 
 	r.state[id] = service
+	r.enqueueCommit()
 }
 
 // FindServiceUnit finds the service with the given id.
 func (r *templateRouter) FindServiceUnit(id string) (v ServiceUnit, ok bool) {
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
 	v, ok = r.state[id]
 	return
 }
 
 // DeleteServiceUnit deletes the service with the given id.
 func (r *templateRouter) DeleteServiceUnit(id string) {
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+
+	if su, ok := r.state[id]; ok {
+		for _, cfg := range su.ServiceAliasConfigs {
+			if r.shouldWriteCerts(&cfg) {
+				r.releaseCertificates(&cfg)
+			}
+		}
+	}
+
 	delete(r.state, id)
+	r.enqueueCommit()
 }
 
 // DeleteEndpoints deletes the endpoints for the service with the given id.
 func (r *templateRouter) DeleteEndpoints(id string) {
-	service, ok := r.FindServiceUnit(id)
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+
+	service, ok := r.state[id]
 	if !ok {
 		return
 	}
 	service.EndpointTable = make(map[string]Endpoint)
 
 	r.state[id] = service
+	r.enqueueCommit()
 }
 
 // routeKey generates route key in form of Namespace-Name.  This is NOT the normal key structure of ns/name because
@@ -232,7 +731,11 @@ func (r *templateRouter) routeKey(route *routeapi.Route) string {
 
 // AddRoute adds a route for the given id
 func (r *templateRouter) AddRoute(id string, route *routeapi.Route) {
-	frontend, _ := r.FindServiceUnit(id)
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+
+	frontend := r.state[id]
+	oldConfig, replacing := frontend.ServiceAliasConfigs[r.routeKey(route)]
 
 	backendKey := r.routeKey(route)
 
@@ -249,13 +752,17 @@ func (r *templateRouter) AddRoute(id string, route *routeapi.Route) {
 				config.Certificates = make(map[string]Certificate)
 			}
 
-			cert := Certificate{
-				ID:         route.Host,
-				Contents:   route.TLS.Certificate,
-				PrivateKey: route.TLS.Key,
-			}
+			if route.TLS.ExternalCertificate != nil {
+				r.addExternalCertificate(&config, route)
+			} else {
+				cert := Certificate{
+					ID:         route.Host,
+					Contents:   route.TLS.Certificate,
+					PrivateKey: route.TLS.Key,
+				}
 
-			config.Certificates[cert.ID] = cert
+				config.Certificates[cert.ID] = cert
+			}
 
 			if len(route.TLS.CACertificate) > 0 {
 				caCert := Certificate{
@@ -274,28 +781,225 @@ func (r *templateRouter) AddRoute(id string, route *routeapi.Route) {
 
 				config.Certificates[destCert.ID] = destCert
 			}
+
+			if r.shouldWriteCerts(&config) {
+				r.trackCertificates(&config)
+			} else {
+				r.requestProvisionedCertificate(&config)
+			}
 		}
 	}
 
 	//create or replace
 	frontend.ServiceAliasConfigs[backendKey] = config
 	r.state[id] = frontend
+	// Released after the new config was tracked, so a certificate shared by both is not deleted.
+	if replacing && r.shouldWriteCerts(&oldConfig) {
+		r.releaseCertificates(&oldConfig)
+	}
+	r.enqueueCommit()
+}
+
+// addExternalCertificate resolves the Secret referenced by route.TLS.ExternalCertificate and
+// populates cfg.Certificates the same way an inline PEM certificate would, so template rendering
+// doesn't need to know the difference.  If no resolver is configured, or the reference cannot be
+// resolved, the route falls back to the default certificate and a warning is logged.
+func (r *templateRouter) addExternalCertificate(cfg *ServiceAliasConfig, route *routeapi.Route) {
+	ref := route.TLS.ExternalCertificate
+	if r.certResolver == nil {
+		glog.Warningf("route %s/%s references external certificate %s/%s but no certificate resolver is configured, falling back to the default certificate",
+			route.Namespace, route.Name, ref.Namespace, ref.Name)
+		return
+	}
+
+	resolved, err := r.resolveExternalCertificate(ref.Namespace, ref.Name)
+	if err != nil {
+		glog.Warningf("unable to resolve external certificate %s/%s for route %s/%s, falling back to the default certificate: %v",
+			ref.Namespace, ref.Name, route.Namespace, route.Name, err)
+		return
+	}
+
+	cert := Certificate{
+		ID:         route.Host,
+		Contents:   string(resolved.Certificate),
+		PrivateKey: string(resolved.Key),
+	}
+	cfg.Certificates[cert.ID] = cert
+
+	if len(resolved.CACertificate) > 0 {
+		caCert := Certificate{
+			ID:       route.Host + caCertPostfix,
+			Contents: string(resolved.CACertificate),
+		}
+		cfg.Certificates[caCert.ID] = caCert
+	}
+}
+
+// resolveExternalCertificate fetches the named Secret via r.certResolver, reusing the cached
+// result if the Secret's UID and resourceVersion haven't changed since the last resolution.
+func (r *templateRouter) resolveExternalCertificate(namespace, name string) (*ResolvedCertificate, error) {
+	resolved, err := r.certResolver.ResolveCertificate(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey := fmt.Sprintf("%s/%s", namespace, name)
+	if cached, ok := r.externalCertCache[cacheKey]; ok && cached.UID == resolved.UID && cached.ResourceVersion == resolved.ResourceVersion {
+		return cached, nil
+	}
+
+	r.externalCertCache[cacheKey] = resolved
+	return resolved, nil
+}
+
+// requestProvisionedCertificate asks the configured CertificateProvisioner for a certificate for
+// cfg.Host if one isn't already being issued.  Only called for edge/reencrypt routes that are
+// missing the required certificates (see shouldWriteCerts).  The route falls back to the default
+// certificate while issuance is pending; once the certificate arrives onCertificateIssued applies
+// it and triggers a Commit so the route doesn't have to wait for its next mutation to pick it up.
+func (r *templateRouter) requestProvisionedCertificate(cfg *ServiceAliasConfig) {
+	if r.provisioner == nil {
+		return
+	}
+	if cfg.TLSTermination != routeapi.TLSTerminationEdge && cfg.TLSTermination != routeapi.TLSTerminationReencrypt {
+		return
+	}
+
+	if issued, ok := r.provisionedCerts[cfg.Host]; ok {
+		r.applyProvisionedCertificate(cfg, issued)
+		return
+	}
+	if requestedAt, ok := r.pendingProvisioning[cfg.Host]; ok && time.Since(requestedAt) < provisioningRequestTimeout {
+		return
+	}
+
+	r.pendingProvisioning[cfg.Host] = time.Now()
+	glog.Infof("requesting a provisioned certificate for host %s", cfg.Host)
+	r.provisioner.RequestCertificate(cfg.Host, r.onCertificateIssued)
+}
+
+// applyProvisionedCertificate installs a previously issued certificate into cfg exactly as an
+// inline or external certificate would be, so template rendering doesn't need to know a
+// certificate's origin.
+func (r *templateRouter) applyProvisionedCertificate(cfg *ServiceAliasConfig, issued IssuedCertificate) {
+	if cfg.Certificates == nil {
+		cfg.Certificates = make(map[string]Certificate)
+	}
+	cfg.Certificates[cfg.Host] = Certificate{
+		ID:         cfg.Host,
+		Contents:   string(issued.Cert),
+		PrivateKey: string(issued.Key),
+	}
+	r.trackCertificates(cfg)
+}
+
+// onCertificateIssued is invoked by the provisioner, from a background goroutine, once a
+// certificate has been issued for a host.  It persists the certificate, applies it to every route
+// for that host, and commits so the new certificate is served without waiting on the next
+// mutation.
+func (r *templateRouter) onCertificateIssued(issued IssuedCertificate) {
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+
+	delete(r.pendingProvisioning, issued.Host)
+	r.provisionedCerts[issued.Host] = issued
+	if err := r.writeProvisionedCerts(); err != nil {
+		glog.Errorf("Error persisting provisioned certificate for %s: %v", issued.Host, err)
+	}
+
+	for id, su := range r.state {
+		for key, cfg := range su.ServiceAliasConfigs {
+			if cfg.Host != issued.Host {
+				continue
+			}
+			// A renewal replaces a certificate this config already holds references to.
+			if r.shouldWriteCerts(&cfg) {
+				r.releaseCertificates(&cfg)
+			}
+			r.applyProvisionedCertificate(&cfg, issued)
+			su.ServiceAliasConfigs[key] = cfg
+		}
+		r.state[id] = su
+	}
+
+	if err := r.commitLocked(); err != nil {
+		glog.Errorf("Error committing router state after certificate issuance for %s: %v", issued.Host, err)
+	}
+}
+
+// runCertificateRenewalLoop periodically checks provisioned certificates and requests renewal
+// once a certificate has reached 2/3 of its lifetime, so a route never serves a
+// provisioner-issued certificate past its expiry.
+func (r *templateRouter) runCertificateRenewalLoop() {
+	ticker := time.NewTicker(certRenewalCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.checkCertificateRenewals()
+	}
+}
+
+func (r *templateRouter) checkCertificateRenewals() {
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+
+	now := time.Now()
+	for host, issued := range r.provisionedCerts {
+		lifetime := issued.NotAfter.Sub(issued.IssuedAt)
+		if lifetime <= 0 {
+			continue
+		}
+		if now.Before(issued.IssuedAt.Add(lifetime * 2 / 3)) {
+			continue
+		}
+		if _, _, ok := r.findConfigByHost(host); !ok {
+			continue
+		}
+		if requestedAt, ok := r.pendingProvisioning[host]; ok && time.Since(requestedAt) < provisioningRequestTimeout {
+			continue
+		}
+		r.pendingProvisioning[host] = time.Now()
+		glog.Infof("requesting renewal of the provisioned certificate for host %s", host)
+		r.provisioner.RequestCertificate(host, r.onCertificateIssued)
+	}
+}
+
+// findConfigByHost returns the first ServiceAliasConfig found for the given host.
+func (r *templateRouter) findConfigByHost(host string) (string, ServiceAliasConfig, bool) {
+	for id, su := range r.state {
+		for _, cfg := range su.ServiceAliasConfigs {
+			if cfg.Host == host {
+				return id, cfg, true
+			}
+		}
+	}
+	return "", ServiceAliasConfig{}, false
 }
 
 // RemoveRoute removes the given route for the given id.
 func (r *templateRouter) RemoveRoute(id string, route *routeapi.Route) {
-	_, ok := r.state[id]
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
 
+	su, ok := r.state[id]
 	if !ok {
 		return
 	}
 
-	delete(r.state[id].ServiceAliasConfigs, r.routeKey(route))
+	key := r.routeKey(route)
+	if cfg, ok := su.ServiceAliasConfigs[key]; ok && r.shouldWriteCerts(&cfg) {
+		r.releaseCertificates(&cfg)
+	}
+
+	delete(su.ServiceAliasConfigs, key)
+	r.enqueueCommit()
 }
 
 // AddEndpoints adds new Endpoints for the given id.
 func (r *templateRouter) AddEndpoints(id string, endpoints []Endpoint) {
-	frontend, _ := r.FindServiceUnit(id)
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+
+	frontend := r.state[id]
 
 	//only add if it doesn't already exist
 	for _, ep := range endpoints {
@@ -306,6 +1010,7 @@ func (r *templateRouter) AddEndpoints(id string, endpoints []Endpoint) {
 	}
 
 	r.state[id] = frontend
+	r.enqueueCommit()
 }
 
 func cmpStrSlices(first []string, second []string) bool {
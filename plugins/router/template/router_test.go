@@ -0,0 +1,58 @@
+package templaterouter
+
+import (
+	"testing"
+
+	routeapi "github.com/openshift/origin/pkg/route/api"
+)
+
+func edgeConfig(host string, cert Certificate) ServiceAliasConfig {
+	return ServiceAliasConfig{
+		Host:           host,
+		TLSTermination: routeapi.TLSTerminationEdge,
+		Certificates: map[string]Certificate{
+			host: cert,
+		},
+	}
+}
+
+func TestRestoreCertificateRefCounts(t *testing.T) {
+	cert := Certificate{ID: "app.example.com", Contents: "cert-bytes", PrivateKey: "key-bytes"}
+	hash := certHash([]byte(cert.Contents))
+
+	r := &templateRouter{
+		state: map[string]ServiceUnit{
+			"ns-one": {
+				Name: "ns-one",
+				ServiceAliasConfigs: map[string]ServiceAliasConfig{
+					"ns-one-route": edgeConfig("app.example.com", cert),
+				},
+			},
+			"ns-two": {
+				Name: "ns-two",
+				ServiceAliasConfigs: map[string]ServiceAliasConfig{
+					"ns-two-route": edgeConfig("app.example.com", cert),
+				},
+			},
+		},
+		certificateRefCounts: map[string]int{},
+		pendingCertWrites:    map[string]Certificate{},
+		certificatesByHash:   map[string]Certificate{},
+	}
+
+	r.restoreCertificateRefCounts()
+
+	if got := r.certificateRefCounts[hash]; got != 2 {
+		t.Fatalf("expected refcount 2 for shared certificate, got %d", got)
+	}
+
+	// Releasing one of the two routes must not delete the file out from under the other.
+	cfg := r.state["ns-one"].ServiceAliasConfigs["ns-one-route"]
+	r.releaseCertificates(&cfg)
+	if _, ok := r.certificateRefCounts[hash]; !ok {
+		t.Fatalf("releasing one of two referencing routes deleted the shared certificate's refcount entirely")
+	}
+	if got := r.certificateRefCounts[hash]; got != 1 {
+		t.Fatalf("expected refcount 1 after releasing one of two referencing routes, got %d", got)
+	}
+}
@@ -0,0 +1,18 @@
+package templaterouter
+
+import "testing"
+
+func TestRouteStatusesReturnsIndependentCopy(t *testing.T) {
+	r := &templateRouter{
+		routeStatus: map[string]RouteStatus{
+			"ns-route": {Degraded: true, Reason: "validation failed"},
+		},
+	}
+
+	statuses := r.RouteStatuses()
+	statuses["ns-route"] = RouteStatus{Degraded: false}
+
+	if got := r.routeStatus["ns-route"]; !got.Degraded {
+		t.Fatalf("mutating the map returned by RouteStatuses mutated r.routeStatus itself")
+	}
+}
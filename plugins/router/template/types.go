@@ -0,0 +1,62 @@
+package templaterouter
+
+import (
+	routeapi "github.com/openshift/origin/pkg/route/api"
+)
+
+// ServiceUnit represents a service that is the target for routes in the router.  It keeps track
+// of all endpoints for the service as well as the routes (ServiceAliasConfigs) that target it.
+type ServiceUnit struct {
+	// Name corresponds to a service name & namespace.  Uniquely identifies the ServiceUnit
+	Name string
+	// ServiceAliasConfigs are the routes that point to this service, keyed by routeKey
+	ServiceAliasConfigs map[string]ServiceAliasConfig
+	// EndpointTable are endpoints that back the service
+	EndpointTable map[string]Endpoint
+}
+
+// ServiceAliasConfig is a route for a service.  Uniquely identified by host + path.
+type ServiceAliasConfig struct {
+	// Host is a required host name ie. www.example.com
+	Host string
+	// Path indicates that path matching should be used and the route should be matched against the path
+	Path string
+	// TLSTermination dictates where the secure communication will be decoded.  If this field is
+	// not specified, the route will be considered insecure (plain HTTP).
+	TLSTermination routeapi.TLSTerminationType
+	// Certificates used for securing this backend, keyed by certificate id
+	Certificates map[string]Certificate
+}
+
+// Certificate represents a public/private key pair used to secure a route, resolved to PEM
+// bytes regardless of whether it originated as an inline value on the route or an external Secret
+// reference.
+type Certificate struct {
+	ID         string
+	Contents   string
+	PrivateKey string
+	// Path is the on-disk location of the written certificate, filled in by
+	// templateRouter.trackCertificates once the certificate has been assigned a content-hash
+	// backed file name.  Templates should reference this rather than deriving a path themselves.
+	Path string
+	// KeyPath is the location templates should read the private key from.  This is the plaintext
+	// on-disk file under certDir when no KeyEncrypter is configured, or the tmpfs-backed plaintext copy
+	// maintained by certManager when one is - see templateRouter.keyPathForHash.
+	KeyPath string
+}
+
+// Endpoint is an endpoint that can be routed to
+type Endpoint struct {
+	ID   string
+	IP   string
+	Port string
+}
+
+// RouteStatus records whether a route is currently being served by the router or was rejected
+// during Commit's validate-before-reload step.
+type RouteStatus struct {
+	// Degraded is true if the route was removed from the live config because it failed validation.
+	Degraded bool
+	// Reason is the validator's error output, present when Degraded is true.
+	Reason string
+}